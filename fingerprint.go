@@ -0,0 +1,399 @@
+package ja3
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// isGrease reports whether v follows the GREASE pattern 0x?a?a used by
+// TLS/QUIC to advertise support for unknown values (RFC 8701).
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a
+}
+
+// extensionId returns the IANA extension number carried by ext, mirroring the
+// type switch utls itself uses internally to marshal extensions.
+func extensionId(ext utls.TLSExtension) (uint16, bool) {
+	switch extData := ext.(type) {
+	case *utls.SNIExtension:
+		return 0, true
+	case *utls.StatusRequestExtension:
+		return 5, true
+	case *utls.SupportedCurvesExtension:
+		return 10, true
+	case *utls.SupportedPointsExtension:
+		return 11, true
+	case *utls.SignatureAlgorithmsExtension:
+		return 13, true
+	case *utls.StatusRequestV2Extension:
+		return 17, true
+	case *utls.ALPNExtension:
+		return 16, true
+	case *utls.SCTExtension:
+		return 18, true
+	case *utls.UtlsPaddingExtension:
+		return 21, true
+	case *utls.FakeTokenBindingExtension:
+		return 24, true
+	case *utls.UtlsExtendedMasterSecretExtension:
+		return 23, true
+	case *utls.FakeRecordSizeLimitExtension:
+		return 28, true
+	case *utls.FakeDelegatedCredentialsExtension:
+		return 34, true
+	case *utls.SessionTicketExtension:
+		return 35, true
+	case *utls.UtlsCompressCertExtension:
+		return 27, true
+	case *utls.PSKKeyExchangeModesExtension:
+		return 45, true
+	case *utls.SupportedVersionsExtension:
+		return 43, true
+	case *utls.CookieExtension:
+		return 44, true
+	case *utls.SignatureAlgorithmsCertExtension:
+		return 50, true
+	case *utls.KeyShareExtension:
+		return 51, true
+	case *utls.QUICTransportParametersExtension:
+		return 57, true
+	case *utls.ApplicationSettingsExtension:
+		return 17513, true
+	case *utls.NPNExtension:
+		return 13172, true
+	case *utls.FakeChannelIDExtension:
+		if extData.OldExtensionID {
+			return 30031, true
+		}
+		return 30032, true
+	case *utls.RenegotiationInfoExtension:
+		return 65281, true
+	case *utls.UtlsGREASEExtension:
+		// GREASE extensions never appear in ja3/ja4 fingerprints,
+		// regardless of which GREASE value they carry.
+		return 0, false
+	case *utls.GenericExtension:
+		return extData.Id, true
+	default:
+		return 0, false
+	}
+}
+
+// Ja3 serializes spec into the classic Salesforce JA3 fingerprint string:
+// SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats.
+// GREASE values are dropped from every field, matching how real clients are
+// fingerprinted.
+func Ja3(spec utls.ClientHelloSpec) string {
+	ciphers := make([]string, 0, len(spec.CipherSuites))
+	for _, cipher := range spec.CipherSuites {
+		if isGrease(cipher) {
+			continue
+		}
+		ciphers = append(ciphers, strconv.Itoa(int(cipher)))
+	}
+	extensions := make([]string, 0, len(spec.Extensions))
+	var curves, points []string
+	for _, ext := range spec.Extensions {
+		id, ok := extensionId(ext)
+		if !ok || isGrease(id) {
+			continue
+		}
+		extensions = append(extensions, strconv.Itoa(int(id)))
+		switch extData := ext.(type) {
+		case *utls.SupportedCurvesExtension:
+			for _, curve := range extData.Curves {
+				if isGrease(uint16(curve)) {
+					continue
+				}
+				curves = append(curves, strconv.Itoa(int(curve)))
+			}
+		case *utls.SupportedPointsExtension:
+			for _, point := range extData.SupportedPoints {
+				points = append(points, strconv.Itoa(int(point)))
+			}
+		}
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(spec.TLSVersMax)),
+		strings.Join(ciphers, "-"),
+		strings.Join(extensions, "-"),
+		strings.Join(curves, "-"),
+		strings.Join(points, "-"),
+	}, ",")
+}
+
+// Ja3Hash returns the MD5 hash of Ja3(spec), the form JA3 is normally shared
+// and matched against.
+func Ja3Hash(spec utls.ClientHelloSpec) string {
+	sum := md5.Sum([]byte(Ja3(spec)))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSpecWithJa3 rebuilds a utls.ClientHelloSpec from a JA3 string of the
+// form SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats.
+// GREASE cipher/extension/curve values are re-inserted at the front of their
+// respective lists so the resulting hello looks like a real browser's rather
+// than a hand-trimmed one.
+func CreateSpecWithJa3(ja3 string) (utls.ClientHelloSpec, error) {
+	var clientHelloSpec utls.ClientHelloSpec
+	tokens := strings.Split(ja3, ",")
+	if len(tokens) != 5 {
+		return clientHelloSpec, errors.New("ja3 format error")
+	}
+	ver, err := strconv.ParseUint(tokens[0], 10, 16)
+	if err != nil {
+		return clientHelloSpec, fmt.Errorf("ja3 version error: %w", err)
+	}
+	clientHelloSpec.TLSVersMin = utls.VersionTLS10
+	clientHelloSpec.TLSVersMax = uint16(ver)
+
+	clientHelloSpec.CipherSuites = append([]uint16{utls.GREASE_PLACEHOLDER}, splitUint16(tokens[1], "-")...)
+	clientHelloSpec.CompressionMethods = []byte{0}
+	clientHelloSpec.GetSessionID = nil
+
+	curves := splitUint16(tokens[3], "-")
+	curveIds := make([]utls.CurveID, 0, len(curves)+1)
+	curveIds = append(curveIds, utls.GREASE_PLACEHOLDER)
+	for _, curve := range curves {
+		curveIds = append(curveIds, utls.CurveID(curve))
+	}
+	points := splitUint16(tokens[4], "-")
+	pointFormats := make([]byte, len(points))
+	for i, point := range points {
+		pointFormats[i] = byte(point)
+	}
+
+	extensions := splitUint16(tokens[2], "-")
+	clientHelloSpec.Extensions = make([]utls.TLSExtension, 0, len(extensions)+2)
+	clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.UtlsGREASEExtension{})
+	for _, extId := range extensions {
+		switch extId {
+		case 0:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.SNIExtension{})
+		case 5:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.StatusRequestExtension{})
+		case 10:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.SupportedCurvesExtension{Curves: curveIds})
+		case 11:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.SupportedPointsExtension{SupportedPoints: pointFormats})
+		case 13:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: defaultSignatureAlgorithms})
+		case 16:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}})
+		case 18:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.SCTExtension{})
+		case 21:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.UtlsPaddingExtension{GetPaddingLen: utls.BoringPaddingStyle})
+		case 23:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.UtlsExtendedMasterSecretExtension{})
+		case 27:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.UtlsCompressCertExtension{Algorithms: []utls.CertCompressionAlgo{utls.CertCompressionBrotli}})
+		case 35:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.SessionTicketExtension{})
+		case 43:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.SupportedVersionsExtension{Versions: []uint16{utls.GREASE_PLACEHOLDER, utls.VersionTLS13, utls.VersionTLS12}})
+		case 45:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}})
+		case 51:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.KeyShareExtension{KeyShares: []utls.KeyShare{
+				{Group: utls.GREASE_PLACEHOLDER, Data: []byte{0}},
+				{Group: utls.X25519},
+			}})
+		case 65281:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient})
+		default:
+			clientHelloSpec.Extensions = append(clientHelloSpec.Extensions, &utls.GenericExtension{Id: uint16(extId)})
+		}
+	}
+	return clientHelloSpec, nil
+}
+
+var defaultSignatureAlgorithms = []utls.SignatureScheme{
+	utls.ECDSAWithP256AndSHA256,
+	utls.PSSWithSHA256,
+	utls.PKCS1WithSHA256,
+	utls.ECDSAWithP384AndSHA384,
+	utls.PSSWithSHA384,
+	utls.PKCS1WithSHA384,
+	utls.PSSWithSHA512,
+	utls.PKCS1WithSHA512,
+}
+
+func splitUint16(s, sep string) []uint16 {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, sep)
+	values := make([]uint16, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 16)
+		if err != nil {
+			continue
+		}
+		values = append(values, uint16(n))
+	}
+	return values
+}
+
+// Ja4 serializes spec into the JA4 fingerprint format (TLS flavour), e.g.
+// "t13d1516h2_xxxxxxxxxxxx_xxxxxxxxxxxx". The a-section is built from plain
+// metadata (version, SNI presence, counts, ALPN); the b/c sections are the
+// truncated SHA256 of the sorted cipher list and sorted extension+signature
+// algorithm list, as specified by FoxIO's JA4 spec.
+func Ja4(spec utls.ClientHelloSpec) string {
+	proto := "t"
+	// The legacy ClientHello.legacy_version field (spec.TLSVersMax) is
+	// pinned to TLS 1.2 by every modern client; the real negotiated
+	// ceiling lives in the supported_versions extension when present.
+	maxVersion := spec.TLSVersMax
+
+	sni := "i"
+	alpn := "00"
+	cipherCount := 0
+	extCount := 0
+	var ciphers, exts []string
+	var sigAlgs []string
+	for _, ext := range spec.Extensions {
+		id, ok := extensionId(ext)
+		if !ok {
+			continue
+		}
+		switch extData := ext.(type) {
+		case *utls.SNIExtension:
+			// SNI is counted in the a-section like any other extension;
+			// only the b/c-section extension-hash list excludes it.
+			sni = "d"
+			extCount++
+			continue
+		case *utls.ALPNExtension:
+			if len(extData.AlpnProtocols) > 0 {
+				a := extData.AlpnProtocols[0]
+				alpn = string(a[0]) + string(a[len(a)-1])
+			}
+			extCount++
+			continue
+		case *utls.SignatureAlgorithmsExtension:
+			for _, sigAlg := range extData.SupportedSignatureAlgorithms {
+				sigAlgs = append(sigAlgs, fmt.Sprintf("%04x", uint16(sigAlg)))
+			}
+		case *utls.SupportedVersionsExtension:
+			for _, v := range extData.Versions {
+				if !isGrease(v) && v > maxVersion {
+					maxVersion = v
+				}
+			}
+		}
+		if isGrease(id) {
+			continue
+		}
+		extCount++
+		exts = append(exts, fmt.Sprintf("%04x", id))
+	}
+	version := ja4Version(maxVersion)
+	for _, cipher := range spec.CipherSuites {
+		if isGrease(cipher) {
+			continue
+		}
+		cipherCount++
+		ciphers = append(ciphers, fmt.Sprintf("%04x", cipher))
+	}
+	sort.Strings(ciphers)
+	sort.Strings(exts)
+
+	a := fmt.Sprintf("%s%s%s%02d%02d%s", proto, version, sni, min(cipherCount, 99), min(extCount, 99), alpn)
+	b := truncatedSha256(strings.Join(ciphers, ","))
+	c := truncatedSha256(strings.Join(append(append([]string{}, exts...), sigAlgs...), ","))
+	return strings.Join([]string{a, b, c}, "_")
+}
+
+func ja4Version(vers uint16) string {
+	switch vers {
+	case utls.VersionTLS13:
+		return "13"
+	case utls.VersionTLS12:
+		return "12"
+	case utls.VersionTLS11:
+		return "11"
+	case utls.VersionTLS10:
+		return "10"
+	default:
+		return "00"
+	}
+}
+
+// CreateSpecWithJa4 rebuilds a ClientHelloSpec skeleton from a JA4 string.
+// JA4's b/c sections are truncated hashes, so the original cipher and
+// extension IDs cannot be recovered; instead only the TLS version and ALPN
+// of the a-section are read to shape a Chrome-style skeleton spec. The
+// a-section's SNI flag and cipher/extension counts are not used: the
+// skeleton always carries the same cipher and extension lists.
+func CreateSpecWithJa4(ja4 string) (utls.ClientHelloSpec, error) {
+	var clientHelloSpec utls.ClientHelloSpec
+	parts := strings.Split(ja4, "_")
+	if len(parts) != 3 || len(parts[0]) < 10 {
+		return clientHelloSpec, errors.New("ja4 format error")
+	}
+	a := parts[0]
+	switch a[1:3] {
+	case "13":
+		clientHelloSpec.TLSVersMax = utls.VersionTLS13
+	case "12":
+		clientHelloSpec.TLSVersMax = utls.VersionTLS12
+	default:
+		clientHelloSpec.TLSVersMax = utls.VersionTLS13
+	}
+	clientHelloSpec.TLSVersMin = utls.VersionTLS10
+
+	alpn := a[len(a)-2:]
+	alpnProtocols := []string{"http/1.1"}
+	if alpn == "h2" || strings.HasPrefix(alpn, "h2") {
+		alpnProtocols = []string{"h2", "http/1.1"}
+	}
+
+	clientHelloSpec.CipherSuites = []uint16{
+		utls.GREASE_PLACEHOLDER,
+		utls.TLS_AES_128_GCM_SHA256,
+		utls.TLS_AES_256_GCM_SHA384,
+		utls.TLS_CHACHA20_POLY1305_SHA256,
+		utls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		utls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		utls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		utls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		utls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		utls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	}
+	clientHelloSpec.CompressionMethods = []byte{0}
+	clientHelloSpec.Extensions = []utls.TLSExtension{
+		&utls.UtlsGREASEExtension{},
+		&utls.SNIExtension{},
+		&utls.UtlsExtendedMasterSecretExtension{},
+		&utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient},
+		&utls.SupportedCurvesExtension{Curves: []utls.CurveID{utls.GREASE_PLACEHOLDER, utls.X25519, utls.CurveP256, utls.CurveP384}},
+		&utls.SupportedPointsExtension{SupportedPoints: []byte{0}},
+		&utls.SessionTicketExtension{},
+		&utls.ALPNExtension{AlpnProtocols: alpnProtocols},
+		&utls.UtlsCompressCertExtension{Algorithms: []utls.CertCompressionAlgo{utls.CertCompressionBrotli}},
+		&utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: defaultSignatureAlgorithms},
+		&utls.KeyShareExtension{KeyShares: []utls.KeyShare{
+			{Group: utls.GREASE_PLACEHOLDER, Data: []byte{0}},
+			{Group: utls.X25519},
+		}},
+		&utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}},
+		&utls.SupportedVersionsExtension{Versions: []uint16{utls.GREASE_PLACEHOLDER, utls.VersionTLS13, utls.VersionTLS12}},
+	}
+	clientHelloSpec.GetSessionID = nil
+	return clientHelloSpec, nil
+}
+
+func truncatedSha256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,70 @@
+package ja3
+
+import (
+	"strings"
+	"testing"
+)
+
+const chromeJa3 = "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513,29-23-24,0"
+
+func TestJa3RoundTrip(t *testing.T) {
+	spec, err := CreateSpecWithJa3(chromeJa3)
+	if err != nil {
+		t.Fatalf("CreateSpecWithJa3: %v", err)
+	}
+	if got := Ja3(spec); got != chromeJa3 {
+		t.Fatalf("round trip mismatch:\nwant %s\ngot  %s", chromeJa3, got)
+	}
+}
+
+func TestJa3Hash(t *testing.T) {
+	spec, err := CreateSpecWithJa3(chromeJa3)
+	if err != nil {
+		t.Fatalf("CreateSpecWithJa3: %v", err)
+	}
+	hash := Ja3Hash(spec)
+	if len(hash) != 32 {
+		t.Fatalf("expected a 32-char md5 hex digest, got %q (len %d)", hash, len(hash))
+	}
+}
+
+func TestCreateSpecWithJa3FormatError(t *testing.T) {
+	if _, err := CreateSpecWithJa3("771,4865,0-23"); err == nil {
+		t.Fatal("expected an error for a ja3 string missing fields")
+	}
+}
+
+func TestJa4Format(t *testing.T) {
+	spec, err := CreateSpecWithJa3(chromeJa3)
+	if err != nil {
+		t.Fatalf("CreateSpecWithJa3: %v", err)
+	}
+	ja4 := Ja4(spec)
+	parts := strings.Split(ja4, "_")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 underscore-separated sections, got %q", ja4)
+	}
+	if !strings.HasPrefix(parts[0], "t13d") {
+		t.Fatalf("expected a TLS1.3 hello with SNI to start with t13d, got %q", parts[0])
+	}
+	if len(parts[1]) != 12 || len(parts[2]) != 12 {
+		t.Fatalf("expected 12-hex-char truncated SHA256 sections, got %q and %q", parts[1], parts[2])
+	}
+}
+
+func TestCreateSpecWithJa4RoundTripsVersionAndAlpn(t *testing.T) {
+	spec, err := CreateSpecWithJa4("t13d1516h2_8daaf6152771_02713d6af862")
+	if err != nil {
+		t.Fatalf("CreateSpecWithJa4: %v", err)
+	}
+	if spec.TLSVersMax != 0x0304 {
+		t.Fatalf("expected TLS 1.3 (0x0304), got %#x", spec.TLSVersMax)
+	}
+	ja4 := Ja4(spec)
+	if !strings.HasPrefix(ja4, "t13d") {
+		t.Fatalf("expected the rebuilt skeleton to still fingerprint as t13d..., got %q", ja4)
+	}
+	if a := strings.Split(ja4, "_")[0]; !strings.HasSuffix(a, "h2") {
+		t.Fatalf("expected the rebuilt skeleton to keep the h2 ALPN, got %q", a)
+	}
+}
@@ -0,0 +1,61 @@
+package ja3
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestH2SpecRoundTrip(t *testing.T) {
+	cases := []H2Spec{
+		DefaultH2Spec(),
+		{},
+		{
+			InitialSetting: []Setting{{Id: Http2SettingHeaderTableSize, Val: 65536}},
+			ConnFlow:       983041,
+			Priorities: []Priority{
+				{StreamID: 3, Exclusive: true, StreamDep: 0, Weight: 255},
+			},
+			OrderHeaders: []string{":method", ":authority", ":scheme", ":path"},
+		},
+	}
+	for i, want := range cases {
+		fp := want.Fp()
+		got, err := CreateH2SpecWithStr(fp)
+		if err != nil {
+			t.Fatalf("case %d: CreateH2SpecWithStr(%q) returned error: %v", i, fp, err)
+		}
+		if got.Fp() != fp {
+			t.Fatalf("case %d: round trip mismatch: want %q, got %q", i, fp, got.Fp())
+		}
+	}
+}
+
+func TestCreateH2SpecWithStrFormatError(t *testing.T) {
+	if _, err := CreateH2SpecWithStr("1:65536|0|0"); err == nil {
+		t.Fatal("expected an error for a spec string missing the headers token")
+	}
+}
+
+// A plain header must not come back as a fabricated pseudo-header: Fp()
+// stability alone (as checked by TestH2SpecRoundTrip) doesn't catch a
+// ":cookie" masquerading as the original "Cookie".
+func TestH2SpecPlainHeadersSurviveRoundTrip(t *testing.T) {
+	want := H2Spec{OrderHeaders: []string{":method", ":authority", ":scheme", ":path", "Host", "Cookie", "M"}}
+	got, err := CreateH2SpecWithStr(want.Fp())
+	if err != nil {
+		t.Fatalf("CreateH2SpecWithStr: %v", err)
+	}
+	wantLower := make([]string, len(want.OrderHeaders))
+	for i, head := range want.OrderHeaders {
+		wantLower[i] = strings.ToLower(head)
+	}
+	if !reflect.DeepEqual(got.OrderHeaders, wantLower) {
+		t.Fatalf("plain headers corrupted on round trip: want %v, got %v", wantLower, got.OrderHeaders)
+	}
+	for _, head := range got.OrderHeaders {
+		if strings.HasPrefix(head, ":") && head != ":method" && head != ":authority" && head != ":scheme" && head != ":path" {
+			t.Fatalf("plain header fabricated into a pseudo-header: %q", head)
+		}
+	}
+}
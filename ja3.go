@@ -10,87 +10,37 @@ import (
 	"net/textproto"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/gospider007/http3"
-	"github.com/gospider007/kinds"
-	"github.com/gospider007/re"
 	utls "github.com/refraction-networking/utls"
 	"golang.org/x/exp/slices"
+	"golang.org/x/net/http2"
 )
 
-type specErr struct {
-	KeyShareExtension *kinds.Set[uint16]
-}
 type Client struct {
-	specErrData sync.Map
+	specCache *specCache
+
+	// ServerNamePolicy, when set, is consulted for the SNI value actually
+	// put on the wire instead of the host the caller asked to connect to.
+	// Learned fingerprint state is still keyed by the real host.
+	ServerNamePolicy ServerNamePolicy
+	// ALPNPolicy, when set, can shuffle or limit the ALPN list built by
+	// createSpecWithSpec on every call, still respecting the h2/h3 toggles.
+	ALPNPolicy ALPNPolicy
 }
 
 func NewClient() *Client {
-	return &Client{}
-}
-func (obj *Client) setSpecErrWithKeyShareExtension(key string, value uint16) (change bool) {
-	errData, ok := obj.specErrData.Load(key)
-	if ok {
-		specErr := errData.(*specErr)
-		if !specErr.KeyShareExtension.Has(value) {
-			change = true
-			specErr.KeyShareExtension.Add(value)
-		}
-	} else {
-		change = true
-		obj.specErrData.Store(key, &specErr{KeyShareExtension: kinds.NewSet(value)})
-	}
-	return
+	return &Client{specCache: newSpecCache(defaultSpecCacheSize, defaultSpecCacheTTL)}
 }
-func (obj *Client) setSpecErrWithError(key string, err error) (change bool) {
-	keyShareExtensionRs := re.Search(`unsupported Curve in KeyShareExtension: CurveID\((\d+)\)`, err.Error())
-	if keyShareExtensionRs != nil {
-		i, err := strconv.Atoi(keyShareExtensionRs.Group(1))
-		if err == nil {
-			if obj.setSpecErrWithKeyShareExtension(key, uint16(i)) {
-				change = true
-			}
-		}
-	}
-	return
-}
-func (obj *Client) changeSpec(key string, spec utls.ClientHelloSpec) (change bool) {
-	errData, ok := obj.specErrData.Load(key)
-	if !ok {
-		return
-	}
-	specErr := errData.(*specErr)
-	for _, ext := range spec.Extensions {
-		switch extData := ext.(type) {
-		case *utls.KeyShareExtension:
-			if specErr.KeyShareExtension.Len() > 0 {
-				keyShares := []utls.KeyShare{}
-				for _, keyShare := range extData.KeyShares {
-					if !specErr.KeyShareExtension.Has(uint16(keyShare.Group)) {
-						change = true
-						keyShares = append(keyShares, keyShare)
-					}
-				}
-				extData.KeyShares = keyShares
-			}
-		case *utls.SupportedCurvesExtension:
-			if specErr.KeyShareExtension.Len() > 0 {
-				keyShares := []utls.CurveID{}
-				for _, keyShare := range extData.Curves {
-					if !specErr.KeyShareExtension.Has(uint16(keyShare)) {
-						change = true
-						keyShares = append(keyShares, keyShare)
-					}
-				}
-				extData.Curves = keyShares
-			}
-		}
-	}
-	return
+
+// NewClientWithCache is like NewClient but lets a long-running crawler size
+// the learned-fingerprint cache and its entry TTL explicitly.
+func NewClientWithCache(size int, ttl time.Duration) *Client {
+	return &Client{specCache: newSpecCache(size, ttl)}
 }
 
-func createSpecWithSpec(utlsSpec utls.ClientHelloSpec, h2 bool, h3 bool) (utls.ClientHelloSpec, error) {
+func createSpecWithSpec(utlsSpec utls.ClientHelloSpec, h2 bool, h3 bool, alpnPolicy ALPNPolicy) (utls.ClientHelloSpec, error) {
 	if h3 {
 		for _, Extension := range utlsSpec.Extensions {
 			alpns, ok := Extension.(*utls.ALPNExtension)
@@ -113,27 +63,39 @@ func createSpecWithSpec(utlsSpec utls.ClientHelloSpec, h2 bool, h3 bool) (utls.C
 			}
 		}
 	}
+	if alpnPolicy != nil {
+		for _, Extension := range utlsSpec.Extensions {
+			alpns, ok := Extension.(*utls.ALPNExtension)
+			if ok {
+				alpns.AlpnProtocols = alpnPolicy.Apply(alpns.AlpnProtocols)
+				break
+			}
+		}
+	}
 	return utlsSpec, nil
 }
 
 func (obj *Client) Client(ctx context.Context, conn net.Conn, ja3Spec utls.ClientHelloSpec, h2 bool, utlsConfig *utls.Config, serverName string) (utlsConn *utls.UConn, err error) {
-	utlsSpec, err := createSpecWithSpec(ja3Spec, h2, false)
+	utlsSpec, err := createSpecWithSpec(ja3Spec, h2, false, obj.ALPNPolicy)
 	if err != nil {
 		return nil, err
 	}
-	utlsConfig.ServerName = serverName
-	obj.changeSpec(serverName, utlsSpec)
+	if obj.ServerNamePolicy != nil {
+		utlsConfig.ServerName = obj.ServerNamePolicy.Pick(serverName)
+	} else {
+		utlsConfig.ServerName = serverName
+	}
+	obj.changeSpec(serverName, &utlsSpec)
 	utlsConn = utls.UClient(conn, utlsConfig, utls.HelloCustom)
-	uspec := utls.ClientHelloSpec(utlsSpec)
 	for {
-		err = utlsConn.ApplyPreset(&uspec)
+		err = utlsConn.ApplyPreset(&utlsSpec)
 		if err == nil {
 			break
 		}
 		if !obj.setSpecErrWithError(serverName, err) {
 			return nil, err
 		}
-		if !obj.changeSpec(serverName, utlsSpec) {
+		if !obj.changeSpec(serverName, &utlsSpec) {
 			return nil, err
 		}
 	}
@@ -160,6 +122,10 @@ type Setting struct {
 	Val uint32
 }
 type Priority struct {
+	// StreamID is the stream this PRIORITY frame is declaring a
+	// dependency for.
+	StreamID uint32
+
 	// StreamDep is a 31-bit stream identifier for the
 	// stream that this stream depends on. Zero means no
 	// dependency.
@@ -177,7 +143,7 @@ type Priority struct {
 
 // have value
 func (obj Priority) IsSet() bool {
-	if obj.StreamDep != 0 || obj.Exclusive || obj.Weight != 0 {
+	if obj.StreamID != 0 || obj.StreamDep != 0 || obj.Exclusive || obj.Weight != 0 {
 		return true
 	}
 	return false
@@ -242,10 +208,13 @@ func DefaultH2Spec() H2Spec {
 		{Id: 4, Val: 6291456},
 		{Id: 6, Val: 262144},
 	}
-	h2Spec.Priority = Priority{
-		Exclusive: true,
-		StreamDep: 0,
-		Weight:    255,
+	h2Spec.Priorities = []Priority{
+		{StreamID: 3, Exclusive: false, StreamDep: 0, Weight: 201},
+		{StreamID: 5, Exclusive: false, StreamDep: 0, Weight: 101},
+		{StreamID: 7, Exclusive: false, StreamDep: 0, Weight: 1},
+		{StreamID: 9, Exclusive: false, StreamDep: 7, Weight: 1},
+		{StreamID: 11, Exclusive: false, StreamDep: 3, Weight: 7},
+		{StreamID: 13, Exclusive: false, StreamDep: 0, Weight: 1},
 	}
 	h2Spec.OrderHeaders = DefaultOrderHeaders()
 	h2Spec.ConnFlow = 15663105
@@ -254,14 +223,14 @@ func DefaultH2Spec() H2Spec {
 
 type H2Spec struct {
 	InitialSetting []Setting
-	ConnFlow       uint32   //WINDOW_UPDATE:15663105
-	OrderHeaders   []string //example：[]string{":method",":authority",":scheme",":path"}
-	Priority       Priority
+	ConnFlow       uint32     //WINDOW_UPDATE:15663105
+	OrderHeaders   []string   //example：[]string{":method",":authority",":scheme",":path"}
+	Priorities     []Priority //PRIORITY frames sent at the connection preface, in order
 }
 
 // have value
 func (obj H2Spec) IsSet() bool {
-	if obj.InitialSetting != nil || obj.ConnFlow != 0 || obj.OrderHeaders != nil || obj.Priority.IsSet() {
+	if obj.InitialSetting != nil || obj.ConnFlow != 0 || obj.OrderHeaders != nil || len(obj.Priorities) > 0 {
 		return true
 	}
 	return false
@@ -272,6 +241,14 @@ func (obj H2Spec) Fp() string {
 	for _, setting := range obj.InitialSetting {
 		settings = append(settings, fmt.Sprintf("%d:%d", setting.Id, setting.Val))
 	}
+	priorities := []string{}
+	for _, priority := range obj.Priorities {
+		exclusive := 0
+		if priority.Exclusive {
+			exclusive = 1
+		}
+		priorities = append(priorities, fmt.Sprintf("%d:%d:%d:%d", priority.StreamID, exclusive, priority.StreamDep, priority.Weight))
+	}
 	heads := []string{}
 	for _, head := range obj.OrderHeaders {
 		head = strings.ToLower(head)
@@ -284,17 +261,23 @@ func (obj H2Spec) Fp() string {
 			heads = append(heads, "s")
 		case ":path":
 			heads = append(heads, "p")
+		default:
+			// Tagged with a "h:" prefix so a plain header can never be
+			// confused with the reserved m/a/s/p pseudo-header tokens on
+			// the way back in CreateH2SpecWithStr (e.g. a header literally
+			// named "M" would otherwise collide with ":method"'s token).
+			heads = append(heads, "h:"+head)
 		}
 	}
 	return strings.Join([]string{
 		strings.Join(settings, ","),
 		fmt.Sprint(obj.ConnFlow),
-		"0",
+		strings.Join(priorities, ","),
 		strings.Join(heads, ","),
 	}, "|")
 }
 
-// example："1:65536,2:0,4:6291456,6:262144|15663105|0|m,a,s,p"
+// example："1:65536,2:0,4:6291456,6:262144|15663105|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:7,13:0:0:1|m,a,s,p"
 func CreateH2SpecWithStr(h2ja3SpecStr string) (h2ja3Spec H2Spec, err error) {
 	tokens := strings.Split(h2ja3SpecStr, "|")
 	if len(tokens) != 4 {
@@ -302,45 +285,112 @@ func CreateH2SpecWithStr(h2ja3SpecStr string) (h2ja3Spec H2Spec, err error) {
 		return
 	}
 	h2ja3Spec.InitialSetting = []Setting{}
-	for _, setting := range strings.Split(tokens[0], ",") {
-		tts := strings.Split(setting, ":")
-		if len(tts) != 2 {
-			err = errors.New("h2 setting error")
-			return
-		}
-		var ttKey, ttVal int
-		if ttKey, err = strconv.Atoi(tts[0]); err != nil {
-			return
-		}
-		if ttVal, err = strconv.Atoi(tts[1]); err != nil {
-			return
+	if tokens[0] != "" {
+		for _, setting := range strings.Split(tokens[0], ",") {
+			tts := strings.Split(setting, ":")
+			if len(tts) != 2 {
+				err = errors.New("h2 setting error")
+				return
+			}
+			var ttKey, ttVal int
+			if ttKey, err = strconv.Atoi(tts[0]); err != nil {
+				return
+			}
+			if ttVal, err = strconv.Atoi(tts[1]); err != nil {
+				return
+			}
+			h2ja3Spec.InitialSetting = append(h2ja3Spec.InitialSetting, Setting{
+				Id:  Http2SettingID(ttKey),
+				Val: uint32(ttVal),
+			})
 		}
-		h2ja3Spec.InitialSetting = append(h2ja3Spec.InitialSetting, Setting{
-			Id:  Http2SettingID(ttKey),
-			Val: uint32(ttVal),
-		})
 	}
 	var connFlow int
 	if connFlow, err = strconv.Atoi(tokens[1]); err != nil {
 		return
 	}
 	h2ja3Spec.ConnFlow = uint32(connFlow)
+	h2ja3Spec.Priorities = []Priority{}
+	if tokens[2] != "" && tokens[2] != "0" {
+		for _, priority := range strings.Split(tokens[2], ",") {
+			pts := strings.Split(priority, ":")
+			if len(pts) != 4 {
+				err = errors.New("h2 priority error")
+				return
+			}
+			var streamId, exclusive, streamDep, weight int
+			if streamId, err = strconv.Atoi(pts[0]); err != nil {
+				return
+			}
+			if exclusive, err = strconv.Atoi(pts[1]); err != nil {
+				return
+			}
+			if streamDep, err = strconv.Atoi(pts[2]); err != nil {
+				return
+			}
+			if weight, err = strconv.Atoi(pts[3]); err != nil {
+				return
+			}
+			h2ja3Spec.Priorities = append(h2ja3Spec.Priorities, Priority{
+				StreamID:  uint32(streamId),
+				Exclusive: exclusive != 0,
+				StreamDep: uint32(streamDep),
+				Weight:    uint8(weight),
+			})
+		}
+	}
 	h2ja3Spec.OrderHeaders = []string{}
-	for _, hkey := range strings.Split(tokens[3], ",") {
-		switch hkey {
-		case "m":
-			h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, ":method")
-		case "a":
-			h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, ":authority")
-		case "s":
-			h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, ":scheme")
-		case "p":
-			h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, ":path")
+	if tokens[3] != "" {
+		for _, hkey := range strings.Split(tokens[3], ",") {
+			switch hkey {
+			case "m":
+				h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, ":method")
+			case "a":
+				h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, ":authority")
+			case "s":
+				h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, ":scheme")
+			case "p":
+				h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, ":path")
+			default:
+				// Fp() tags a plain header with a "h:" prefix precisely so
+				// it can't collide with the m/a/s/p tokens above.
+				h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, strings.TrimPrefix(hkey, "h:"))
+			}
 		}
 	}
 	return
 }
 
+// Apply writes obj's SETTINGS, WINDOW_UPDATE and PRIORITY frames onto framer
+// in the exact order captured in the fingerprint. This is what actually
+// reproduces the fingerprint on the wire at connection preface time; setting
+// H2Spec on a request without calling Apply only affects what the HTTP/2
+// transport itself negotiates.
+func (obj H2Spec) Apply(framer *http2.Framer) error {
+	settings := make([]http2.Setting, len(obj.InitialSetting))
+	for i, setting := range obj.InitialSetting {
+		settings[i] = http2.Setting{ID: http2.SettingID(setting.Id), Val: setting.Val}
+	}
+	if err := framer.WriteSettings(settings...); err != nil {
+		return err
+	}
+	if obj.ConnFlow != 0 {
+		if err := framer.WriteWindowUpdate(0, obj.ConnFlow); err != nil {
+			return err
+		}
+	}
+	for _, priority := range obj.Priorities {
+		if err := framer.WritePriority(priority.StreamID, http2.PriorityParam{
+			StreamDep: priority.StreamDep,
+			Exclusive: priority.Exclusive,
+			Weight:    priority.Weight,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func CreateSpecWithClientHello(clienthello any) (clientHelloSpec utls.ClientHelloSpec, err error) {
 	var clientHelloInfo ClientHello
 	switch value := clienthello.(type) {
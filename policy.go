@@ -0,0 +1,128 @@
+package ja3
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+)
+
+// ServerNamePolicy picks the SNI value to actually send on the wire for a
+// given target host, letting callers defeat SNI-based blocklists and
+// per-SNI fingerprint pinning without rewriting Client themselves. Learned
+// fingerprint state in Client.specCache stays keyed by the real host
+// regardless of what Pick returns.
+type ServerNamePolicy interface {
+	Pick(host string) string
+}
+
+const (
+	defaultSNICharset = "abcdefghijklmnopqrstuvwxyz"
+	defaultSNILength  = 12
+)
+
+type randomSNIPolicy struct {
+	charset string
+	length  int
+}
+
+// PerConnRandomSNI returns a ServerNamePolicy that generates a fresh random
+// SNI of length characters drawn from charset on every call to Pick,
+// ignoring the requested host entirely.
+func PerConnRandomSNI(charset string, length int) ServerNamePolicy {
+	return randomSNIPolicy{charset: charset, length: length}
+}
+
+func (obj randomSNIPolicy) Pick(host string) string {
+	charset := obj.charset
+	if charset == "" {
+		charset = defaultSNICharset
+	}
+	length := obj.length
+	if length <= 0 {
+		length = defaultSNILength
+	}
+	out := make([]byte, length)
+	for i := range out {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			out[i] = charset[0]
+			continue
+		}
+		out[i] = charset[n.Int64()]
+	}
+	return string(out)
+}
+
+// RandomSNI is a ServerNamePolicy that sends a fresh random lowercase SNI on
+// every connection, equivalent to Cloak's "ServerName: random".
+var RandomSNI ServerNamePolicy = PerConnRandomSNI(defaultSNICharset, defaultSNILength)
+
+type roundRobinSNIPolicy struct {
+	mu   sync.Mutex
+	list []string
+	next int
+}
+
+// RoundRobinSNI returns a ServerNamePolicy that cycles through list in order
+// on every call to Pick, analogous to Cloak's AlternativeNames rotation. It
+// panics if list is empty.
+func RoundRobinSNI(list []string) ServerNamePolicy {
+	if len(list) == 0 {
+		panic("ja3: RoundRobinSNI requires a non-empty list")
+	}
+	return &roundRobinSNIPolicy{list: list}
+}
+
+func (obj *roundRobinSNIPolicy) Pick(host string) string {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	name := obj.list[obj.next%len(obj.list)]
+	obj.next++
+	return name
+}
+
+// ALPNPolicy shuffles or limits the ALPN list createSpecWithSpec builds for
+// a connection, applied after the h2/h3 toggles have already set the
+// protocol list.
+type ALPNPolicy interface {
+	Apply(alpn []string) []string
+}
+
+type shuffleALPNPolicy struct{}
+
+// ShuffleALPNPolicy returns an ALPNPolicy that randomly permutes the ALPN
+// list on every call.
+func ShuffleALPNPolicy() ALPNPolicy {
+	return shuffleALPNPolicy{}
+}
+
+func (shuffleALPNPolicy) Apply(alpn []string) []string {
+	shuffled := make([]string, len(alpn))
+	copy(shuffled, alpn)
+	for i := len(shuffled) - 1; i > 0; i-- {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			continue
+		}
+		j := n.Int64()
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	return shuffled
+}
+
+type limitALPNPolicy struct {
+	n int
+}
+
+// LimitALPNPolicy returns an ALPNPolicy that truncates the ALPN list to at
+// most n entries. An n of zero or less leaves the list unchanged.
+func LimitALPNPolicy(n int) ALPNPolicy {
+	return limitALPNPolicy{n: n}
+}
+
+func (obj limitALPNPolicy) Apply(alpn []string) []string {
+	if obj.n <= 0 || len(alpn) <= obj.n {
+		return alpn
+	}
+	return alpn[:obj.n]
+}
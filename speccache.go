@@ -0,0 +1,362 @@
+package ja3
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gospider007/kinds"
+	"github.com/gospider007/re"
+	lru "github.com/hashicorp/golang-lru/v2"
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	defaultSpecCacheSize = 1024
+	defaultSpecCacheTTL  = 30 * time.Minute
+)
+
+// specErr records, per server name, every TLS negotiation outcome that
+// required the ClientHello spec to be pruned before the handshake would
+// succeed: rejected KeyShareExtension curves, signature algorithms, cipher
+// suites, TLS versions and ALPN protocols.
+type specErr struct {
+	mu sync.Mutex
+
+	KeyShareExtension   *kinds.Set[uint16]
+	SignatureAlgorithms map[uint16]struct{}
+	CipherSuites        map[uint16]struct{}
+	SupportedVersions   map[uint16]struct{}
+	ALPN                map[string]struct{}
+
+	expireAt time.Time
+}
+
+func newSpecErr(ttl time.Duration) *specErr {
+	return &specErr{
+		KeyShareExtension:   kinds.NewSet[uint16](),
+		SignatureAlgorithms: map[uint16]struct{}{},
+		CipherSuites:        map[uint16]struct{}{},
+		SupportedVersions:   map[uint16]struct{}{},
+		ALPN:                map[string]struct{}{},
+		expireAt:            time.Now().Add(ttl),
+	}
+}
+
+// LearnedSpec is a point-in-time snapshot of what has been learned about a
+// host's TLS negotiation quirks, returned by Client.Learned for
+// observability.
+type LearnedSpec struct {
+	KeyShareExtension   []uint16
+	SignatureAlgorithms []uint16
+	CipherSuites        []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+}
+
+// specCache is an LRU-bounded, TTL-expiring store of per-host specErr
+// entries. It replaces the unbounded sync.Map a long-running crawler would
+// otherwise grow without limit.
+type specCache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	lru *lru.Cache[string, *specErr]
+}
+
+func newSpecCache(size int, ttl time.Duration) *specCache {
+	if size <= 0 {
+		size = defaultSpecCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultSpecCacheTTL
+	}
+	l, _ := lru.New[string, *specErr](size)
+	return &specCache{lru: l, ttl: ttl}
+}
+
+func (obj *specCache) get(key string) (*specErr, bool) {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	entry, ok := obj.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expireAt) {
+		obj.lru.Remove(key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (obj *specCache) getOrCreate(key string) *specErr {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	if entry, ok := obj.lru.Get(key); ok && !time.Now().After(entry.expireAt) {
+		return entry
+	}
+	entry := newSpecErr(obj.ttl)
+	obj.lru.Add(key, entry)
+	return entry
+}
+
+func (obj *Client) setSpecErrWithKeyShareExtension(key string, value uint16) (change bool) {
+	entry := obj.specCache.getOrCreate(key)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if !entry.KeyShareExtension.Has(value) {
+		change = true
+		entry.KeyShareExtension.Add(value)
+	}
+	return
+}
+
+func addUint16(entry *specErr, set map[uint16]struct{}, value uint16) (change bool) {
+	if _, ok := set[value]; !ok {
+		set[value] = struct{}{}
+		change = true
+	}
+	return
+}
+
+func (obj *Client) setSpecErrWithError(key string, err error) (change bool) {
+	errText := err.Error()
+	if rs := re.Search(`unsupported Curve in KeyShareExtension: CurveID\((\d+)\)`, errText); rs != nil {
+		if i, atoiErr := strconv.Atoi(rs.Group(1)); atoiErr == nil {
+			if obj.setSpecErrWithKeyShareExtension(key, uint16(i)) {
+				change = true
+			}
+		}
+	}
+	entry := obj.specCache.getOrCreate(key)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if rs := re.Search(`unsupported signature algorithm: SignatureScheme\((\d+)\)`, errText); rs != nil {
+		if i, atoiErr := strconv.Atoi(rs.Group(1)); atoiErr == nil {
+			if addUint16(entry, entry.SignatureAlgorithms, uint16(i)) {
+				change = true
+			}
+		}
+	}
+	// utls rejects an unmatched cipher suite with a bare "tls: server chose
+	// an unconfigured cipher suite" - the offending suite ID is known inside
+	// the library (hs.serverHello.cipherSuite) but is never surfaced on the
+	// returned error, so unlike KeyShareExtension/SignatureAlgorithms above
+	// there is no value here to prune by. Left undetected rather than
+	// guessed at; CipherSuites stays populated only by LoadState.
+	if rs := re.Search(`tls: server selected unsupported protocol version ([0-9a-fA-F]+)`, errText); rs != nil {
+		if i, atoiErr := strconv.ParseUint(rs.Group(1), 16, 16); atoiErr == nil {
+			if addUint16(entry, entry.SupportedVersions, uint16(i)) {
+				change = true
+			}
+		}
+	}
+	// Same limitation applies to ALPN: utls's three ALPN failure modes
+	// ("tls: server did not select an ALPN protocol", "...advertised
+	// unrequested ALPN extension", "...selected unadvertised ALPN
+	// protocol") never quote the protocol string, so individual ALPN
+	// values can't be learned from the error text either; ALPN stays
+	// populated only by LoadState.
+	return
+}
+
+// changeSpec prunes spec in place using everything learned so far for
+// key, returning whether anything was actually removed so the caller
+// knows whether retrying the handshake is worthwhile.
+func (obj *Client) changeSpec(key string, spec *utls.ClientHelloSpec) (change bool) {
+	entry, ok := obj.specCache.get(key)
+	if !ok {
+		return false
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if len(entry.CipherSuites) > 0 {
+		ciphers := make([]uint16, 0, len(spec.CipherSuites))
+		for _, cipher := range spec.CipherSuites {
+			if _, bad := entry.CipherSuites[cipher]; bad {
+				change = true
+				continue
+			}
+			ciphers = append(ciphers, cipher)
+		}
+		spec.CipherSuites = ciphers
+	}
+	for _, ext := range spec.Extensions {
+		switch extData := ext.(type) {
+		case *utls.KeyShareExtension:
+			if entry.KeyShareExtension.Len() > 0 {
+				keyShares := []utls.KeyShare{}
+				for _, keyShare := range extData.KeyShares {
+					if !entry.KeyShareExtension.Has(uint16(keyShare.Group)) {
+						keyShares = append(keyShares, keyShare)
+					} else {
+						change = true
+					}
+				}
+				extData.KeyShares = keyShares
+			}
+		case *utls.SupportedCurvesExtension:
+			if entry.KeyShareExtension.Len() > 0 {
+				curves := []utls.CurveID{}
+				for _, curve := range extData.Curves {
+					if !entry.KeyShareExtension.Has(uint16(curve)) {
+						curves = append(curves, curve)
+					} else {
+						change = true
+					}
+				}
+				extData.Curves = curves
+			}
+		case *utls.SignatureAlgorithmsExtension:
+			if len(entry.SignatureAlgorithms) > 0 {
+				schemes := []utls.SignatureScheme{}
+				for _, scheme := range extData.SupportedSignatureAlgorithms {
+					if _, bad := entry.SignatureAlgorithms[uint16(scheme)]; bad {
+						change = true
+						continue
+					}
+					schemes = append(schemes, scheme)
+				}
+				extData.SupportedSignatureAlgorithms = schemes
+			}
+		case *utls.SupportedVersionsExtension:
+			if len(entry.SupportedVersions) > 0 {
+				versions := []uint16{}
+				for _, version := range extData.Versions {
+					if _, bad := entry.SupportedVersions[version]; bad {
+						change = true
+						continue
+					}
+					versions = append(versions, version)
+				}
+				extData.Versions = versions
+			}
+		case *utls.ALPNExtension:
+			if len(entry.ALPN) > 0 {
+				protocols := []string{}
+				for _, protocol := range extData.AlpnProtocols {
+					if _, bad := entry.ALPN[protocol]; bad {
+						change = true
+						continue
+					}
+					protocols = append(protocols, protocol)
+				}
+				extData.AlpnProtocols = protocols
+			}
+		}
+	}
+	return
+}
+
+// Learned returns a snapshot of everything the client has learned about
+// host's TLS negotiation quirks so far. The zero value is returned for hosts
+// that have never failed a handshake.
+func (obj *Client) Learned(host string) LearnedSpec {
+	entry, ok := obj.specCache.get(host)
+	if !ok {
+		return LearnedSpec{}
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	learned := LearnedSpec{}
+	for _, v := range entry.KeyShareExtension.Array() {
+		learned.KeyShareExtension = append(learned.KeyShareExtension, v)
+	}
+	for v := range entry.SignatureAlgorithms {
+		learned.SignatureAlgorithms = append(learned.SignatureAlgorithms, v)
+	}
+	for v := range entry.CipherSuites {
+		learned.CipherSuites = append(learned.CipherSuites, v)
+	}
+	for v := range entry.SupportedVersions {
+		learned.SupportedVersions = append(learned.SupportedVersions, v)
+	}
+	for v := range entry.ALPN {
+		learned.ALPN = append(learned.ALPN, v)
+	}
+	return learned
+}
+
+// persistedEntry is the on-disk form written/read by SaveState/LoadState.
+type persistedEntry struct {
+	Host                string   `json:"host"`
+	KeyShareExtension   []uint16 `json:"key_share_extension,omitempty"`
+	SignatureAlgorithms []uint16 `json:"signature_algorithms,omitempty"`
+	CipherSuites        []uint16 `json:"cipher_suites,omitempty"`
+	SupportedVersions   []uint16 `json:"supported_versions,omitempty"`
+	ALPN                []string `json:"alpn,omitempty"`
+	ExpireAt            int64    `json:"expire_at"`
+}
+
+// SaveState serializes every still-live learned spec to w as JSON, so a
+// long-running crawler can persist what it has learned across restarts.
+func (obj *Client) SaveState(w io.Writer) error {
+	obj.specCache.mu.Lock()
+	keys := obj.specCache.lru.Keys()
+	obj.specCache.mu.Unlock()
+
+	entries := make([]persistedEntry, 0, len(keys))
+	for _, host := range keys {
+		entry, ok := obj.specCache.get(host)
+		if !ok {
+			continue
+		}
+		entry.mu.Lock()
+		p := persistedEntry{Host: host, ExpireAt: entry.expireAt.Unix()}
+		p.KeyShareExtension = entry.KeyShareExtension.Array()
+		for v := range entry.SignatureAlgorithms {
+			p.SignatureAlgorithms = append(p.SignatureAlgorithms, v)
+		}
+		for v := range entry.CipherSuites {
+			p.CipherSuites = append(p.CipherSuites, v)
+		}
+		for v := range entry.SupportedVersions {
+			p.SupportedVersions = append(p.SupportedVersions, v)
+		}
+		for v := range entry.ALPN {
+			p.ALPN = append(p.ALPN, v)
+		}
+		entry.mu.Unlock()
+		entries = append(entries, p)
+	}
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// LoadState restores learned specs previously written by SaveState, skipping
+// any that have already expired.
+func (obj *Client) LoadState(r io.Reader) error {
+	var entries []persistedEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, p := range entries {
+		expireAt := time.Unix(p.ExpireAt, 0)
+		if !expireAt.After(now) {
+			continue
+		}
+		entry := newSpecErr(obj.specCache.ttl)
+		entry.expireAt = expireAt
+		for _, v := range p.KeyShareExtension {
+			entry.KeyShareExtension.Add(v)
+		}
+		for _, v := range p.SignatureAlgorithms {
+			entry.SignatureAlgorithms[v] = struct{}{}
+		}
+		for _, v := range p.CipherSuites {
+			entry.CipherSuites[v] = struct{}{}
+		}
+		for _, v := range p.SupportedVersions {
+			entry.SupportedVersions[v] = struct{}{}
+		}
+		for _, v := range p.ALPN {
+			entry.ALPN[v] = struct{}{}
+		}
+		obj.specCache.mu.Lock()
+		obj.specCache.lru.Add(p.Host, entry)
+		obj.specCache.mu.Unlock()
+	}
+	return nil
+}
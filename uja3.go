@@ -1,9 +1,13 @@
 package ja3
 
 import (
+	"context"
 	"errors"
+	"net"
 
+	"github.com/gospider007/http3"
 	uquic "github.com/refraction-networking/uquic"
+	utls "github.com/refraction-networking/utls"
 )
 
 type USpec struct {
@@ -32,3 +36,67 @@ func CreateUSpec(value any) (uquic.QUICSpec, error) {
 		return uquic.QUICSpec{}, errors.New("unsupported type")
 	}
 }
+
+// QUICClient establishes a uquic session over pconn using uSpec as the QUIC
+// transport/ClientHello fingerprint, forcing ALPN to h3 so the resulting
+// connection can be handed to github.com/gospider007/http3. It reuses the
+// same self-healing loop as Client: when the embedded ClientHello spec is
+// rejected for an unsupported curve, the offending values are remembered for
+// serverName and pruned before the next attempt.
+func (obj *Client) QUICClient(ctx context.Context, pconn net.PacketConn, remote net.Addr, uSpec uquic.QUICSpec, tlsCfg *utls.Config, quicCfg *uquic.Config, serverName string) (uquic.EarlyConnection, error) {
+	if obj.ServerNamePolicy != nil {
+		tlsCfg.ServerName = obj.ServerNamePolicy.Pick(serverName)
+	} else {
+		tlsCfg.ServerName = serverName
+	}
+	tlsCfg.NextProtos = []string{http3.NextProtoH3}
+	if uSpec.ClientHelloSpec != nil {
+		obj.changeSpec(serverName, uSpec.ClientHelloSpec)
+	}
+	tr := &uquic.UTransport{
+		Transport: &uquic.Transport{Conn: pconn},
+		QUICSpec:  &uSpec,
+	}
+	for {
+		conn, err := tr.DialEarly(ctx, remote, tlsCfg, quicCfg)
+		if err == nil {
+			return conn, nil
+		}
+		if !obj.setSpecErrWithError(serverName, err) {
+			return nil, err
+		}
+		if uSpec.ClientHelloSpec == nil || !obj.changeSpec(serverName, uSpec.ClientHelloSpec) {
+			return nil, err
+		}
+	}
+}
+
+// CreateUSpecWithInitialPacket builds a uquic.QUICSpec skeleton from a
+// captured QUIC long-header Initial packet, mirroring CreateSpecWithClientHello
+// for TLS. Only the cleartext long-header connection ID lengths are read back
+// here and placed on InitialPacketSpec: the QUIC version isn't part of
+// QUICSpec (it belongs on Config.Versions instead), and the Initial packet's
+// payload is AEAD-protected under secrets derived from the destination
+// connection ID, so recovering the embedded ClientHello fingerprint requires
+// decrypting it separately (e.g. via CreateSpecWithClientHello once the
+// CRYPTO frame bytes have been extracted) and attaching the result to the
+// returned spec's ClientHelloSpec field.
+func CreateUSpecWithInitialPacket(packet []byte) (uquic.QUICSpec, error) {
+	if len(packet) < 7 || packet[0]&0x80 == 0 {
+		return uquic.QUICSpec{}, errors.New("not a long-header QUIC packet")
+	}
+	if packet[0]&0x30 != 0x00 {
+		return uquic.QUICSpec{}, errors.New("not an Initial packet")
+	}
+	destConnIdLen := int(packet[5])
+	if len(packet) < 6+destConnIdLen+1 {
+		return uquic.QUICSpec{}, errors.New("truncated Initial packet")
+	}
+	srcConnIdLen := int(packet[6+destConnIdLen])
+	return uquic.QUICSpec{
+		InitialPacketSpec: uquic.InitialPacketSpec{
+			SrcConnIDLength:  srcConnIdLen,
+			DestConnIDLength: destConnIdLen,
+		},
+	}, nil
+}